@@ -1,12 +1,21 @@
 package main
 
 import (
+	"log"
+
+	"github.com/trenton42/miniredis/internal/resp"
 	"github.com/trenton42/miniredis/internal/server"
 	"github.com/trenton42/miniredis/internal/storage"
 )
 
 func main() {
 	v := storage.New()
+
+	r := resp.New(v)
+	go func() {
+		log.Fatal(r.Serve(6379))
+	}()
+
 	s := server.New(v)
 	s.Serve(8787)
 }
@@ -0,0 +1,287 @@
+// Package cluster wraps a storage.Storage with a Raft consensus layer so a
+// minikeystore node can run as part of a 3- or 5-node cluster with strong
+// consistency instead of as a single point of failure.
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	"github.com/trenton42/miniredis/internal/storage"
+)
+
+// ErrNotLeader is returned by every mutating Cluster method, and by Join and
+// Leave, when called against a node that is not the current Raft leader.
+// Callers (the HTTP server) are expected to redirect to LeaderHTTPAddr.
+var ErrNotLeader = fmt.Errorf("cluster: not the leader")
+
+// Peer describes one member of the cluster: its Raft identity plus the HTTP
+// address clients should be redirected to when that node is the leader.
+type Peer struct {
+	ID       string
+	RaftAddr string
+	HTTPAddr string
+}
+
+// Config describes how to start this node as part of a Raft-replicated
+// cluster.
+type Config struct {
+	// NodeID is this node's unique Raft server ID.
+	NodeID string
+	// RaftAddr is the address this node's Raft transport binds and
+	// advertises.
+	RaftAddr string
+	// HTTPAddr is the address this node serves the store's HTTP API on; it
+	// is only used so other nodes can redirect writes to this one when it
+	// is the leader.
+	HTTPAddr string
+	// Peers lists every node in the cluster, including this one. On first
+	// start, the node with the lowest NodeID among Peers bootstraps the
+	// cluster with every listed peer as a voter; a node that is not in
+	// Peers joins via POST /_cluster/join.
+	Peers []Peer
+	// DataDir holds this node's Raft log, stable store and snapshots.
+	DataDir string
+}
+
+// Cluster wraps a storage.Storage so that mutations are only ever applied
+// locally after a quorum of the cluster has agreed on them.
+type Cluster struct {
+	raft    *raft.Raft
+	storage *storage.Storage
+	self    Config
+}
+
+// New starts (or rejoins) a Raft node backed by store and returns the
+// wrapper that routes mutations through it. store should be freshly created
+// (storage.New()) since its content is replaced by whatever the cluster
+// replays or restores from snapshot.
+func New(cfg Config, store *storage.Storage) (*Cluster, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("cluster: %v", err)
+	}
+
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(cfg.NodeID)
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.RaftAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: resolving %s: %v", cfg.RaftAddr, err)
+	}
+	transport, err := raft.NewTCPTransport(cfg.RaftAddr, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: %v", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.DataDir, 2, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: %v", err)
+	}
+
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft-log.bolt"))
+	if err != nil {
+		return nil, fmt.Errorf("cluster: %v", err)
+	}
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft-stable.bolt"))
+	if err != nil {
+		return nil, fmt.Errorf("cluster: %v", err)
+	}
+
+	f := &fsm{storage: store}
+	r, err := raft.NewRaft(raftCfg, f, logStore, stableStore, snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: %v", err)
+	}
+
+	c := &Cluster{raft: r, storage: store, self: cfg}
+
+	if bootstrapLeader(cfg) {
+		servers := make([]raft.Server, 0, len(cfg.Peers))
+		for _, p := range cfg.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(p.ID), Address: raft.ServerAddress(p.RaftAddr)})
+		}
+		if len(servers) == 0 {
+			servers = []raft.Server{{ID: raftCfg.LocalID, Address: transport.LocalAddr()}}
+		}
+		r.BootstrapCluster(raft.Configuration{Servers: servers})
+	}
+
+	return c, nil
+}
+
+// bootstrapLeader decides, deterministically across every node's identical
+// Peers list, which single node is responsible for calling
+// raft.BootstrapCluster on first start. It is the node with the
+// lexicographically smallest ID, or this node itself if no peers were
+// configured (single-node dev mode).
+func bootstrapLeader(cfg Config) bool {
+	if len(cfg.Peers) == 0 {
+		return true
+	}
+	lowest := cfg.Peers[0].ID
+	for _, p := range cfg.Peers[1:] {
+		if p.ID < lowest {
+			lowest = p.ID
+		}
+	}
+	return cfg.NodeID == lowest
+}
+
+// propose applies cmd through Raft, blocking until it has either committed
+// and been applied to the FSM, or failed.
+func (c *Cluster) propose(cmd command) (interface{}, error) {
+	if c.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	data, err := cmd.encode()
+	if err != nil {
+		return nil, err
+	}
+	future := c.raft.Apply(data, 10*time.Second)
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	if err, ok := future.Response().(error); ok {
+		return nil, err
+	}
+	return future.Response(), nil
+}
+
+// Set proposes a Set through Raft.
+func (c *Cluster) Set(key string, value interface{}) error {
+	_, err := c.propose(command{Op: opSet, Key: key, Value: value})
+	return err
+}
+
+// Delete proposes a Delete through Raft.
+func (c *Cluster) Delete(key string) error {
+	_, err := c.propose(command{Op: opDelete, Key: key})
+	return err
+}
+
+// Append proposes an Append through Raft.
+func (c *Cluster) Append(key, value string) error {
+	_, err := c.propose(command{Op: opAppend, Key: key, Value: value})
+	return err
+}
+
+// Pop proposes a Pop through Raft and returns the popped value once a
+// quorum has applied it.
+func (c *Cluster) Pop(key string) (string, error) {
+	resp, err := c.propose(command{Op: opPop, Key: key})
+	if err != nil {
+		return "", err
+	}
+	val, _ := resp.(string)
+	return val, nil
+}
+
+// MapSet proposes a MapSet through Raft.
+func (c *Cluster) MapSet(key, mkey, value string) error {
+	_, err := c.propose(command{Op: opMapSet, Key: key, MKey: mkey, Value: value})
+	return err
+}
+
+// MapDelete proposes a MapDelete through Raft.
+func (c *Cluster) MapDelete(key, mkey string) error {
+	_, err := c.propose(command{Op: opMapDelete, Key: key, MKey: mkey})
+	return err
+}
+
+// Expire proposes an Expire through Raft.
+func (c *Cluster) Expire(key string, ttl time.Duration) error {
+	_, err := c.propose(command{Op: opExpire, Key: key, Value: ttl.Seconds()})
+	return err
+}
+
+// Persist proposes a Persist through Raft.
+func (c *Cluster) Persist(key string) error {
+	_, err := c.propose(command{Op: opPersist, Key: key})
+	return err
+}
+
+// Get reads key. A linearizable read goes through a Raft read-index barrier
+// first so it reflects every write committed before it was issued; a stale
+// read is served straight from local storage, which may lag the leader.
+func (c *Cluster) Get(key string, linearizable bool) ([]byte, error) {
+	if linearizable {
+		if err := c.barrier(); err != nil {
+			return nil, err
+		}
+	}
+	return c.storage.Get(key)
+}
+
+// barrier blocks until every write proposed before it was called has been
+// applied locally. Only the leader can serve one, since only the leader
+// knows it still holds quorum.
+func (c *Cluster) barrier() error {
+	if c.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return c.raft.Barrier(10 * time.Second).Error()
+}
+
+// Join adds nodeID, reachable at raftAddr, as a voting member of the
+// cluster. It must be called against the current leader.
+func (c *Cluster) Join(nodeID, raftAddr string) error {
+	if c.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return c.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0).Error()
+}
+
+// Leave removes nodeID from the cluster. It must be called against the
+// current leader.
+func (c *Cluster) Leave(nodeID string) error {
+	if c.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return c.raft.RemoveServer(raft.ServerID(nodeID), 0, 0).Error()
+}
+
+// Status reports this node's view of the cluster.
+type Status struct {
+	NodeID string   `json:"node_id"`
+	State  string   `json:"state"`
+	Leader string   `json:"leader"`
+	Peers  []string `json:"peers"`
+}
+
+// Status returns this node's current Raft state and leader.
+func (c *Cluster) Status() Status {
+	var peers []string
+	if cfgFuture := c.raft.GetConfiguration(); cfgFuture.Error() == nil {
+		for _, srv := range cfgFuture.Configuration().Servers {
+			peers = append(peers, string(srv.ID)+"@"+string(srv.Address))
+		}
+	}
+	return Status{
+		NodeID: c.self.NodeID,
+		State:  c.raft.State().String(),
+		Leader: string(c.raft.Leader()),
+		Peers:  peers,
+	}
+}
+
+// LeaderHTTPAddr returns the HTTP address of the current Raft leader, as
+// looked up in Config.Peers, or "" if no leader is known or it isn't one of
+// the configured peers.
+func (c *Cluster) LeaderHTTPAddr() string {
+	leaderRaftAddr := string(c.raft.Leader())
+	if leaderRaftAddr == "" {
+		return ""
+	}
+	for _, p := range c.self.Peers {
+		if p.RaftAddr == leaderRaftAddr {
+			return p.HTTPAddr
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/trenton42/miniredis/internal/storage"
+)
+
+// op identifies which storage.Storage method a command applies.
+type op string
+
+const (
+	opSet       op = "set"
+	opDelete    op = "delete"
+	opAppend    op = "append"
+	opPop       op = "pop"
+	opMapSet    op = "mapset"
+	opMapDelete op = "mapdelete"
+	opExpire    op = "expire"
+	opPersist   op = "persist"
+)
+
+// command is the payload proposed through Raft for every mutating
+// operation. It is JSON-encoded rather than gob so the log entries stay
+// human-readable when inspected on disk, matching the style of the
+// existing HTTP Request/Response types.
+type command struct {
+	Op    op          `json:"op"`
+	Key   string      `json:"key"`
+	MKey  string      `json:"mkey,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (c command) encode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// fsm applies committed Raft log entries to the underlying storage.Storage.
+// It never touches storage directly outside of Apply/Snapshot/Restore - all
+// client-facing mutations go through Cluster.propose so they are replicated
+// first.
+type fsm struct {
+	storage *storage.Storage
+}
+
+// Apply decodes a committed log entry and performs it against storage. Its
+// return value becomes the response seen by the caller that proposed it, on
+// whichever node that was.
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("cluster: invalid command: %v", err)
+	}
+	switch cmd.Op {
+	case opSet:
+		return f.storage.Set(cmd.Key, cmd.Value)
+	case opDelete:
+		f.storage.Delete(cmd.Key)
+		return nil
+	case opAppend:
+		value, _ := cmd.Value.(string)
+		return f.storage.Append(cmd.Key, value)
+	case opPop:
+		val, err := f.storage.Pop(cmd.Key)
+		if err != nil {
+			return err
+		}
+		return val
+	case opMapSet:
+		value, _ := cmd.Value.(string)
+		return f.storage.MapSet(cmd.Key, cmd.MKey, value)
+	case opMapDelete:
+		return f.storage.MapDelete(cmd.Key, cmd.MKey)
+	case opExpire:
+		seconds, _ := cmd.Value.(float64)
+		return f.storage.Expire(cmd.Key, time.Duration(seconds*float64(time.Second)))
+	case opPersist:
+		return f.storage.Persist(cmd.Key)
+	default:
+		return fmt.Errorf("cluster: unknown op %q", cmd.Op)
+	}
+}
+
+// Snapshot captures the current state for Raft to compact its log against.
+// It reuses storage's own WAL snapshot format instead of inventing a
+// second one, so the same bytes Open/Close checkpoint to disk are what
+// Raft ships to a lagging follower.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return &fsmSnapshot{storage: f.storage}, nil
+}
+
+// Restore replaces the current state with a snapshot taken by Snapshot,
+// either our own or one shipped from another node.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	return f.storage.ReadSnapshot(rc)
+}
+
+// fsmSnapshot defers the actual write until Raft is ready to persist it,
+// per the raft.FSMSnapshot contract.
+type fsmSnapshot struct {
+	storage *storage.Storage
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.storage.WriteSnapshot(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
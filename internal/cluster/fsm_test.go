@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/trenton42/miniredis/internal/storage"
+)
+
+func applyCmd(t *testing.T, f *fsm, cmd command) interface{} {
+	t.Helper()
+	data, err := cmd.encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return f.Apply(&raft.Log{Data: data})
+}
+
+func TestFSMApplySet(t *testing.T) {
+	f := &fsm{storage: storage.New()}
+
+	if err := applyCmd(t, f, command{Op: opSet, Key: "a", Value: "hello"}); err != nil {
+		t.Fatalf("apply set: %v", err)
+	}
+	val, err := f.storage.Get("a")
+	if err != nil || string(val) != `"hello"` {
+		t.Errorf("expected a == hello, got %s err %v", val, err)
+	}
+}
+
+func TestFSMApplyAppendAndPop(t *testing.T) {
+	f := &fsm{storage: storage.New()}
+
+	applyCmd(t, f, command{Op: opAppend, Key: "list", Value: "one"})
+	applyCmd(t, f, command{Op: opAppend, Key: "list", Value: "two"})
+
+	resp := applyCmd(t, f, command{Op: opPop, Key: "list"})
+	if resp != "two" {
+		t.Errorf("expected pop to return two, got %v", resp)
+	}
+}
+
+func TestFSMApplyExpireAndPersist(t *testing.T) {
+	f := &fsm{storage: storage.New()}
+
+	applyCmd(t, f, command{Op: opSet, Key: "a", Value: "hello"})
+	if err := applyCmd(t, f, command{Op: opExpire, Key: "a", Value: float64(60)}); err != nil {
+		t.Fatalf("apply expire: %v", err)
+	}
+	if ttl, err := f.storage.TTL("a"); err != nil || ttl <= 0 {
+		t.Errorf("expected a positive TTL after expire, got %v err %v", ttl, err)
+	}
+
+	if err := applyCmd(t, f, command{Op: opPersist, Key: "a"}); err != nil {
+		t.Fatalf("apply persist: %v", err)
+	}
+	if ttl, err := f.storage.TTL("a"); err != nil || ttl != -1 {
+		t.Errorf("expected no TTL after persist, got %v err %v", ttl, err)
+	}
+}
+
+func TestFSMApplyUnknownOp(t *testing.T) {
+	f := &fsm{storage: storage.New()}
+
+	resp := applyCmd(t, f, command{Op: "bogus", Key: "a"})
+	if _, ok := resp.(error); !ok {
+		t.Errorf("expected an error for an unknown op, got %v", resp)
+	}
+}
+
+func TestFSMSnapshotRestore(t *testing.T) {
+	src := &fsm{storage: storage.New()}
+	applyCmd(t, src, command{Op: opSet, Key: "a", Value: "hello"})
+	applyCmd(t, src, command{Op: opMapSet, Key: "m", MKey: "k", Value: "v"})
+
+	snap, err := src.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	sink := newFakeSnapshotSink()
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+
+	dst := &fsm{storage: storage.New()}
+	if err := dst.Restore(sink.reader()); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	val, err := dst.storage.Get("a")
+	if err != nil || string(val) != `"hello"` {
+		t.Errorf("expected a == hello after restore, got %s err %v", val, err)
+	}
+	mval, err := dst.storage.MapGet("m", "k")
+	if err != nil || mval != "v" {
+		t.Errorf("expected m.k == v after restore, got %q err %v", mval, err)
+	}
+}
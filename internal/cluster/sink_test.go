@@ -0,0 +1,26 @@
+package cluster
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// fakeSnapshotSink is a minimal raft.SnapshotSink backed by an in-memory
+// buffer, just enough to exercise fsm.Snapshot/Restore without standing up
+// a real raft.FileSnapshotStore.
+type fakeSnapshotSink struct {
+	bytes.Buffer
+}
+
+func newFakeSnapshotSink() *fakeSnapshotSink {
+	return &fakeSnapshotSink{}
+}
+
+func (s *fakeSnapshotSink) reader() io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader(s.Bytes()))
+}
+
+func (s *fakeSnapshotSink) ID() string    { return "test" }
+func (s *fakeSnapshotSink) Cancel() error { return nil }
+func (s *fakeSnapshotSink) Close() error  { return nil }
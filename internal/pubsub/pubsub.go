@@ -0,0 +1,232 @@
+// Package pubsub implements an in-process event broker for minikeystore's
+// keyspace notifications and user-defined PUBLISH/SUBSCRIBE channels. A
+// single Broker fans out Events to any number of Subscribers, matching each
+// subscriber's pattern against the event's channel either exactly or as a
+// glob (reusing the same go-glob matcher storage.GetIndex uses).
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	glob "github.com/ryanuber/go-glob"
+)
+
+// Keyspace event ops, one per mutating storage.Storage method that fires a
+// notification. OpMessage is used instead for PUBLISH, which addresses an
+// arbitrary channel independent of the keyspace.
+const (
+	OpSet       = "set"
+	OpDel       = "del"
+	OpAppend    = "append"
+	OpPop       = "pop"
+	OpMapSet    = "mapset"
+	OpMapDelete = "mapdelete"
+	OpExpired   = "expired"
+	OpMessage   = "message"
+)
+
+// subscriberBuffer bounds how many undelivered events a single slow
+// subscriber can accumulate before Broker.Publish starts dropping its
+// oldest ones rather than blocking the publisher.
+const subscriberBuffer = 64
+
+// logLimit bounds how many past events Broker.Since/Wait can replay for the
+// long-poll transport. A cursor older than the oldest retained event simply
+// misses whatever was pruned, the same tradeoff the per-subscriber buffer
+// makes for push delivery.
+const logLimit = 1024
+
+// Event is one keyspace mutation or PUBLISHed message, as delivered to
+// subscribers and returned by Since/Wait. Channel is either the mutated key
+// (for keyspace events) or the channel name a client PUBLISHed to. Seq is a
+// broker-wide monotonically increasing cursor; Dropped reports how many
+// earlier events this subscriber missed because it wasn't keeping up, so a
+// slow client can tell it lost events instead of silently falling behind.
+type Event struct {
+	Seq     uint64 `json:"seq"`
+	Channel string `json:"channel"`
+	Op      string `json:"op"`
+	Value   string `json:"value,omitempty"`
+	Dropped int    `json:"dropped,omitempty"`
+}
+
+// Broker fans out Events to Subscribers and keeps a bounded replay log for
+// the long-poll transport. The zero value is not usable; use New.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[*Subscriber]struct{}
+	seq  uint64
+	log  []Event
+
+	// notify is closed and replaced on every Publish, so Wait can block on
+	// it instead of polling for new events.
+	notify chan struct{}
+}
+
+// New creates an empty Broker.
+func New() *Broker {
+	return &Broker{
+		subs:   make(map[*Subscriber]struct{}),
+		notify: make(chan struct{}),
+	}
+}
+
+// Subscriber receives Events whose Channel matches Pattern (exact or glob),
+// delivered through a bounded, non-blocking buffer. Close it once the
+// caller is done consuming Events to stop Broker.Publish from holding a
+// reference to it.
+type Subscriber struct {
+	pattern string
+	events  chan Event
+	broker  *Broker
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Events returns the channel s's matching Events arrive on. Once Close is
+// called, later Publish calls no longer route to s, but an event from a
+// Publish already in flight may still land in the buffer.
+func (s *Subscriber) Events() <-chan Event {
+	return s.events
+}
+
+// Close unsubscribes s from its Broker. It does not close the Events
+// channel: a concurrent Publish may already be about to deliver to it, and
+// closing here could race with that send.
+func (s *Subscriber) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// deliver enqueues ev for s, dropping the oldest buffered event (and
+// counting it in Dropped on some later event) instead of blocking if s's
+// buffer is full. This is what keeps one slow subscriber from stalling
+// Broker.Publish, and therefore every storage mutation.
+func (s *Subscriber) deliver(ev Event) {
+	s.mu.Lock()
+	ev.Dropped = s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+
+	select {
+	case s.events <- ev:
+		return
+	default:
+	}
+
+	// Buffer is full: evict the oldest queued event to make room for ev,
+	// and count it as dropped so the next event delivered reports the loss.
+	select {
+	case <-s.events:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	default:
+	}
+	select {
+	case s.events <- ev:
+	default:
+		// Lost the race with another evict/send; count ev itself as
+		// dropped rather than block.
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+}
+
+// Subscribe registers a new Subscriber matching pattern, which may be an
+// exact channel name or a go-glob pattern such as "foo*".
+func (b *Broker) Subscribe(pattern string) *Subscriber {
+	s := &Subscriber{pattern: pattern, events: make(chan Event, subscriberBuffer), broker: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Broker) unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
+
+// Publish records an event on channel and fans it out to every matching
+// subscriber. It never blocks on a subscriber: see Subscriber.deliver.
+func (b *Broker) Publish(channel, op, value string) Event {
+	b.mu.Lock()
+	b.seq++
+	ev := Event{Seq: b.seq, Channel: channel, Op: op, Value: value}
+	b.log = append(b.log, ev)
+	if len(b.log) > logLimit {
+		b.log = b.log[len(b.log)-logLimit:]
+	}
+	subs := make([]*Subscriber, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	notify := b.notify
+	b.notify = make(chan struct{})
+	b.mu.Unlock()
+
+	close(notify)
+	for _, s := range subs {
+		if matches(s.pattern, ev.Channel) {
+			s.deliver(ev)
+		}
+	}
+	return ev
+}
+
+func matches(pattern, channel string) bool {
+	return pattern == channel || glob.Glob(pattern, channel)
+}
+
+// Since returns every logged event with Seq > since whose Channel matches
+// pattern, plus the cursor the caller should pass as since on its next
+// call. The cursor advances to the newest logged Seq even when nothing
+// matched, so a long-poll loop on a pattern with no traffic doesn't rescan
+// the whole log on every call.
+func (b *Broker) Since(pattern string, since uint64) ([]Event, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cursor := since
+	var matched []Event
+	for _, ev := range b.log {
+		if ev.Seq <= since {
+			continue
+		}
+		cursor = ev.Seq
+		if matches(pattern, ev.Channel) {
+			matched = append(matched, ev)
+		}
+	}
+	return matched, cursor
+}
+
+// Wait is Since, but blocks until at least one matching event arrives or
+// timeout elapses, backing the HTTP long-poll transport.
+func (b *Broker) Wait(pattern string, since uint64, timeout time.Duration) ([]Event, uint64) {
+	deadline := time.Now().Add(timeout)
+	for {
+		b.mu.Lock()
+		notify := b.notify
+		b.mu.Unlock()
+
+		matched, cursor := b.Since(pattern, since)
+		if len(matched) > 0 {
+			return matched, cursor
+		}
+		since = cursor
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return matched, cursor
+		}
+		select {
+		case <-notify:
+		case <-time.After(remaining):
+			return matched, cursor
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeExactChannel(t *testing.T) {
+	b := New()
+	sub := b.Subscribe("foo")
+	defer sub.Close()
+
+	b.Publish("foo", OpMessage, "hello")
+	b.Publish("bar", OpMessage, "ignored")
+
+	select {
+	case ev := <-sub.Events():
+		if ev.Channel != "foo" || ev.Value != "hello" {
+			t.Errorf("got %+v, want channel foo value hello", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	select {
+	case ev := <-sub.Events():
+		t.Errorf("unexpected second event %+v, exact subscriber should not match \"bar\"", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeGlobPattern(t *testing.T) {
+	b := New()
+	sub := b.Subscribe("user:*")
+	defer sub.Close()
+
+	b.Publish("user:42", OpSet, "")
+	b.Publish("order:1", OpSet, "")
+
+	select {
+	case ev := <-sub.Events():
+		if ev.Channel != "user:42" {
+			t.Errorf("got channel %q, want user:42", ev.Channel)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	select {
+	case ev := <-sub.Events():
+		t.Errorf("unexpected event %+v for non-matching channel", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPublishDropsOldestWhenBufferFull(t *testing.T) {
+	b := New()
+	sub := b.Subscribe("*")
+	defer sub.Close()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		b.Publish("k", OpSet, "")
+	}
+
+	var last Event
+	for {
+		select {
+		case ev := <-sub.Events():
+			last = ev
+			continue
+		default:
+		}
+		break
+	}
+	if last.Dropped == 0 {
+		t.Error("expected some events to have been dropped for a buffer that overflowed")
+	}
+}
+
+func TestSinceReturnsOnlyNewerMatchingEvents(t *testing.T) {
+	b := New()
+	b.Publish("a", OpSet, "")
+	_, cursor := b.Since("*", 0)
+	b.Publish("a", OpSet, "")
+	b.Publish("b", OpSet, "")
+
+	events, newCursor := b.Since("a", cursor)
+	if len(events) != 1 || events[0].Channel != "a" {
+		t.Errorf("got %+v, want one event for channel a", events)
+	}
+	if newCursor <= cursor {
+		t.Errorf("cursor did not advance: got %d, want > %d", newCursor, cursor)
+	}
+}
+
+func TestWaitReturnsImmediatelyOnExistingBacklog(t *testing.T) {
+	b := New()
+	b.Publish("a", OpSet, "")
+
+	events, _ := b.Wait("a", 0, time.Second)
+	if len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestWaitWakesOnPublish(t *testing.T) {
+	b := New()
+	_, cursor := b.Since("*", 0)
+
+	done := make(chan []Event, 1)
+	go func() {
+		events, _ := b.Wait("a", cursor, 5*time.Second)
+		done <- events
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	b.Publish("a", OpSet, "")
+
+	select {
+	case events := <-done:
+		if len(events) != 1 {
+			t.Errorf("got %d events, want 1", len(events))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not wake on Publish")
+	}
+}
+
+func TestWaitTimesOutWithNoEvents(t *testing.T) {
+	b := New()
+	start := time.Now()
+	events, _ := b.Wait("a", 0, 20*time.Millisecond)
+	if len(events) != 0 {
+		t.Errorf("got %d events, want 0", len(events))
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("Wait returned before its timeout elapsed")
+	}
+}
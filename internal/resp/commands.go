@@ -0,0 +1,303 @@
+package resp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dispatch runs one already-parsed command against s.s and writes its
+// reply to w. It returns true if the connection should be closed after the
+// reply is flushed (QUIT).
+func (s *Server) dispatch(w *bufio.Writer, args []string) bool {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		writeSimpleString(w, "PONG")
+	case "ECHO":
+		s.handleEcho(w, args)
+	case "SELECT":
+		s.handleSelect(w, args)
+	case "COMMAND":
+		writeArray(w, []string{})
+	case "QUIT":
+		writeSimpleString(w, "OK")
+		return true
+	case "GET":
+		s.handleGet(w, args)
+	case "SET":
+		s.handleSet(w, args)
+	case "DEL":
+		s.handleDel(w, args)
+	case "RPUSH":
+		s.handlePush(w, args, s.s.Append)
+	case "LPUSH":
+		s.handlePush(w, args, s.s.Lpush)
+	case "RPOP":
+		s.handlePop(w, args, s.s.Pop)
+	case "LPOP":
+		s.handlePop(w, args, s.s.Lpop)
+	case "LRANGE":
+		s.handleLrange(w, args)
+	case "LLEN":
+		s.handleLlen(w, args)
+	case "HGET":
+		s.handleHget(w, args)
+	case "HSET":
+		s.handleHset(w, args)
+	case "HDEL":
+		s.handleHdel(w, args)
+	case "HGETALL":
+		s.handleHgetall(w, args)
+	case "HKEYS":
+		s.handleHkeys(w, args)
+	case "KEYS":
+		s.handleKeys(w, args)
+	default:
+		writeError(w, fmt.Errorf("unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+func wrongArgs(w *bufio.Writer, cmd string) {
+	writeError(w, fmt.Errorf("wrong number of arguments for '%s' command", cmd))
+}
+
+func (s *Server) handleEcho(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "echo")
+		return
+	}
+	writeBulkString(w, args[1])
+}
+
+func (s *Server) handleSelect(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "select")
+		return
+	}
+	if args[1] != "0" {
+		writeError(w, fmt.Errorf("minikeystore only has database 0"))
+		return
+	}
+	writeSimpleString(w, "OK")
+}
+
+// handleGet serves GET; unlike the HTTP API's Get, which returns whatever
+// JSON-marshaled value is stored, RESP's GET is string-only and errors on
+// anything else, matching Redis's WRONGTYPE behaviour.
+func (s *Server) handleGet(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "get")
+		return
+	}
+	raw, err := s.s.Get(args[1])
+	if err != nil {
+		writeNilBulk(w)
+		return
+	}
+	var val string
+	if err := json.Unmarshal(raw, &val); err != nil {
+		writeError(w, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		return
+	}
+	writeBulkString(w, val)
+}
+
+func (s *Server) handleSet(w *bufio.Writer, args []string) {
+	if len(args) != 3 {
+		wrongArgs(w, "set")
+		return
+	}
+	if err := s.s.Set(args[1], args[2]); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeSimpleString(w, "OK")
+}
+
+func (s *Server) handleDel(w *bufio.Writer, args []string) {
+	if len(args) < 2 {
+		wrongArgs(w, "del")
+		return
+	}
+	var removed int
+	for _, key := range args[1:] {
+		if _, err := s.s.Get(key); err == nil {
+			removed++
+		}
+		s.s.Delete(key)
+	}
+	writeInteger(w, removed)
+}
+
+// handlePush backs both RPUSH and LPUSH, which only differ in which
+// storage method pushes the value.
+func (s *Server) handlePush(w *bufio.Writer, args []string, push func(string, string) error) {
+	if len(args) < 3 {
+		wrongArgs(w, "rpush/lpush")
+		return
+	}
+	key := args[1]
+	for _, value := range args[2:] {
+		if err := push(key, value); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	n, err := s.s.Llen(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeInteger(w, n)
+}
+
+// handlePop backs both RPOP and LPOP, which only differ in which storage
+// method removes the value.
+func (s *Server) handlePop(w *bufio.Writer, args []string, pop func(string) (string, error)) {
+	if len(args) != 2 {
+		wrongArgs(w, "rpop/lpop")
+		return
+	}
+	val, err := pop(args[1])
+	if err != nil {
+		writeNilBulk(w)
+		return
+	}
+	writeBulkString(w, val)
+}
+
+func (s *Server) handleLrange(w *bufio.Writer, args []string) {
+	if len(args) != 4 {
+		wrongArgs(w, "lrange")
+		return
+	}
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		writeError(w, fmt.Errorf("value is not an integer or out of range"))
+		return
+	}
+	items, err := s.s.Lrange(args[1], start, stop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeArray(w, items)
+}
+
+func (s *Server) handleLlen(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "llen")
+		return
+	}
+	n, err := s.s.Llen(args[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeInteger(w, n)
+}
+
+func (s *Server) handleHget(w *bufio.Writer, args []string) {
+	if len(args) != 3 {
+		wrongArgs(w, "hget")
+		return
+	}
+	val, err := s.s.MapGet(args[1], args[2])
+	if err != nil {
+		writeNilBulk(w)
+		return
+	}
+	writeBulkString(w, val)
+}
+
+func (s *Server) handleHset(w *bufio.Writer, args []string) {
+	if len(args) != 4 {
+		wrongArgs(w, "hset")
+		return
+	}
+	_, existed := s.s.MapGet(args[1], args[2])
+	if err := s.s.MapSet(args[1], args[2], args[3]); err != nil {
+		writeError(w, err)
+		return
+	}
+	if existed == nil {
+		writeInteger(w, 0)
+		return
+	}
+	writeInteger(w, 1)
+}
+
+func (s *Server) handleHdel(w *bufio.Writer, args []string) {
+	if len(args) < 3 {
+		wrongArgs(w, "hdel")
+		return
+	}
+	var removed int
+	for _, field := range args[2:] {
+		if _, err := s.s.MapGet(args[1], field); err == nil {
+			removed++
+		}
+		s.s.MapDelete(args[1], field)
+	}
+	writeInteger(w, removed)
+}
+
+func (s *Server) handleHgetall(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "hgetall")
+		return
+	}
+	m, err := s.getMap(args[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	items := make([]string, 0, len(m)*2)
+	for k, v := range m {
+		items = append(items, k, v)
+	}
+	writeArray(w, items)
+}
+
+func (s *Server) handleHkeys(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "hkeys")
+		return
+	}
+	m, err := s.getMap(args[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	writeArray(w, keys)
+}
+
+// getMap fetches key as a map[string]string, returning an empty map for a
+// missing key and an error for a key holding a non-map value.
+func (s *Server) getMap(key string) (map[string]string, error) {
+	raw, err := s.s.Get(key)
+	if err != nil {
+		return map[string]string{}, nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return m, nil
+}
+
+func (s *Server) handleKeys(w *bufio.Writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "keys")
+		return
+	}
+	writeArray(w, s.s.GetIndex(args[1]))
+}
@@ -0,0 +1,97 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/trenton42/miniredis/internal/storage"
+)
+
+// run dispatches a single command against a fresh Server and returns its
+// raw RESP reply.
+func run(t *testing.T, srv *Server, args ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	srv.dispatch(w, args)
+	w.Flush()
+	return buf.String()
+}
+
+func TestDispatchStringOps(t *testing.T) {
+	srv := New(storage.New())
+
+	if got := run(t, srv, "SET", "a", "hello"); got != "+OK\r\n" {
+		t.Errorf("SET reply = %q", got)
+	}
+	if got := run(t, srv, "GET", "a"); got != "$5\r\nhello\r\n" {
+		t.Errorf("GET reply = %q", got)
+	}
+	if got := run(t, srv, "GET", "missing"); got != "$-1\r\n" {
+		t.Errorf("GET missing reply = %q", got)
+	}
+	if got := run(t, srv, "DEL", "a", "missing"); got != ":1\r\n" {
+		t.Errorf("DEL reply = %q", got)
+	}
+	if got := run(t, srv, "GET", "a"); got != "$-1\r\n" {
+		t.Errorf("GET after DEL reply = %q", got)
+	}
+}
+
+func TestDispatchListOps(t *testing.T) {
+	srv := New(storage.New())
+
+	run(t, srv, "RPUSH", "list", "a", "b")
+	run(t, srv, "LPUSH", "list", "z")
+
+	if got := run(t, srv, "LLEN", "list"); got != ":3\r\n" {
+		t.Errorf("LLEN reply = %q", got)
+	}
+	if got := run(t, srv, "LRANGE", "list", "0", "-1"); got != "*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n" {
+		t.Errorf("LRANGE reply = %q", got)
+	}
+	if got := run(t, srv, "LPOP", "list"); got != "$1\r\nz\r\n" {
+		t.Errorf("LPOP reply = %q", got)
+	}
+	if got := run(t, srv, "RPOP", "list"); got != "$1\r\nb\r\n" {
+		t.Errorf("RPOP reply = %q", got)
+	}
+}
+
+func TestDispatchHashOps(t *testing.T) {
+	srv := New(storage.New())
+
+	if got := run(t, srv, "HSET", "h", "k", "v"); got != ":1\r\n" {
+		t.Errorf("HSET reply = %q", got)
+	}
+	if got := run(t, srv, "HSET", "h", "k", "v2"); got != ":0\r\n" {
+		t.Errorf("HSET update reply = %q", got)
+	}
+	if got := run(t, srv, "HGET", "h", "k"); got != "$2\r\nv2\r\n" {
+		t.Errorf("HGET reply = %q", got)
+	}
+	if got := run(t, srv, "HDEL", "h", "k"); got != ":1\r\n" {
+		t.Errorf("HDEL reply = %q", got)
+	}
+}
+
+func TestDispatchMisc(t *testing.T) {
+	srv := New(storage.New())
+
+	if got := run(t, srv, "PING"); got != "+PONG\r\n" {
+		t.Errorf("PING reply = %q", got)
+	}
+	if got := run(t, srv, "ECHO", "hi"); got != "$2\r\nhi\r\n" {
+		t.Errorf("ECHO reply = %q", got)
+	}
+	if got := run(t, srv, "SELECT", "0"); got != "+OK\r\n" {
+		t.Errorf("SELECT 0 reply = %q", got)
+	}
+	if got := run(t, srv, "SELECT", "1"); got[0] != '-' {
+		t.Errorf("expected SELECT 1 to error, got %q", got)
+	}
+	if got := run(t, srv, "BOGUS"); got[0] != '-' {
+		t.Errorf("expected unknown command to error, got %q", got)
+	}
+}
@@ -0,0 +1,71 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadCommandMultibulk(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$5\r\nhello\r\n"))
+	args, err := readCommand(r)
+	if err != nil {
+		t.Fatalf("readCommand: %v", err)
+	}
+	if !checkArgs(args, []string{"SET", "a", "hello"}) {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestReadCommandInline(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PING\r\n"))
+	args, err := readCommand(r)
+	if err != nil {
+		t.Fatalf("readCommand: %v", err)
+	}
+	if !checkArgs(args, []string{"PING"}) {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestReadCommandPipelined(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PING\r\nPING\r\n"))
+	for i := 0; i < 2; i++ {
+		args, err := readCommand(r)
+		if err != nil {
+			t.Fatalf("[cmd %d] readCommand: %v", i, err)
+		}
+		if !checkArgs(args, []string{"PING"}) {
+			t.Errorf("[cmd %d] unexpected args: %v", i, args)
+		}
+	}
+}
+
+func TestWriteHelpers(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeSimpleString(w, "OK")
+	writeInteger(w, 3)
+	writeBulkString(w, "hi")
+	writeNilBulk(w)
+	writeArray(w, []string{"a", "b"})
+	w.Flush()
+
+	want := "+OK\r\n:3\r\n$2\r\nhi\r\n$-1\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n got:  %q\n want: %q", buf.String(), want)
+	}
+}
+
+func checkArgs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+// Package resp implements a minimal Redis RESP2 frontend for minikeystore,
+// so any redis-cli or Redis client can talk to it as a second transport
+// alongside the existing HTTP server.Server.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+)
+
+// store is the subset of storage.Storage this package drives commands
+// against. It mirrors internal/server's store interface without its TTL
+// methods (Expire, Persist, TTL), plus the list operations RESP's list
+// commands need.
+type store interface {
+	Get(string) ([]byte, error)
+	Set(string, interface{}) error
+	Delete(string)
+	Append(string, string) error
+	Pop(string) (string, error)
+	Lpush(string, string) error
+	Lpop(string) (string, error)
+	Llen(string) (int, error)
+	Lrange(string, int, int) ([]string, error)
+	MapGet(string, string) (string, error)
+	MapSet(string, string, string) error
+	MapDelete(string, string) error
+	GetIndex(string) []string
+}
+
+// Server listens for RESP2 connections and dispatches commands against a
+// store.
+type Server struct {
+	s store
+}
+
+// New creates a resp.Server. It takes an instance that fulfils interface store.
+func New(s store) *Server {
+	return &Server{s: s}
+}
+
+// Serve listens for connections and starts the server. It blocks until the
+// listener fails, so callers typically run it in its own goroutine.
+func (s *Server) Serve(port int) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("resp: %v", err)
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("resp: %v", err)
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn services one client connection until it disconnects, sends
+// QUIT, or the protocol is violated. Commands are read and dispatched in a
+// simple loop, which is all pipelining requires: a client can write many
+// requests before reading any reply, and the kernel buffers them on the
+// socket independently of when we get around to reading and answering each
+// one.
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.dispatch(w, args)
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if quit {
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+
+	"github.com/trenton42/miniredis/internal/cluster"
+	"github.com/trenton42/miniredis/internal/pubsub"
+	"github.com/trenton42/miniredis/internal/storage"
+)
+
+// NewClustered creates a Server backed by a Raft-replicated cluster instead
+// of a single in-process Storage. Every mutating handler proposes its
+// command through Raft and only applies it via the FSM once a quorum has
+// agreed; reads default to being served from local storage unless the
+// caller asks for linearizable consistency.
+func NewClustered(cfg cluster.Config) (*Server, error) {
+	st := storage.New()
+	c, err := cluster.New(cfg, st)
+	if err != nil {
+		return nil, err
+	}
+	var server Server
+	server.s = st
+	server.cluster = c
+	server.router = echo.New()
+	server.broker = pubsub.New()
+	st.SetNotifier(server.broker)
+	return &server, nil
+}
+
+// ClusterJoinRequest is the body of POST /_cluster/join.
+type ClusterJoinRequest struct {
+	NodeID   string `json:"node_id"`
+	RaftAddr string `json:"raft_addr"`
+}
+
+// ClusterJoin adds a new voting member to the cluster. It must be sent to
+// the current leader.
+func (s *Server) ClusterJoin(c echo.Context) error {
+	var req ClusterJoinRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, Response{Error: err.Error()})
+	}
+	if err := s.cluster.Join(req.NodeID, req.RaftAddr); err != nil {
+		if err == cluster.ErrNotLeader {
+			return s.redirectToLeader(c)
+		}
+		return c.JSON(http.StatusBadRequest, Response{Error: err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ClusterLeaveRequest is the body of POST /_cluster/leave.
+type ClusterLeaveRequest struct {
+	NodeID string `json:"node_id"`
+}
+
+// ClusterLeave removes a member from the cluster. It must be sent to the
+// current leader.
+func (s *Server) ClusterLeave(c echo.Context) error {
+	var req ClusterLeaveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, Response{Error: err.Error()})
+	}
+	if err := s.cluster.Leave(req.NodeID); err != nil {
+		if err == cluster.ErrNotLeader {
+			return s.redirectToLeader(c)
+		}
+		return c.JSON(http.StatusBadRequest, Response{Error: err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ClusterStatus reports this node's view of the cluster.
+func (s *Server) ClusterStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.cluster.Status())
+}
+
+// redirectToLeader sends a write or linearizable read that landed on a
+// follower to the current leader's HTTP address instead of failing it
+// outright.
+func (s *Server) redirectToLeader(c echo.Context) error {
+	addr := s.cluster.LeaderHTTPAddr()
+	if addr == "" {
+		return c.JSON(http.StatusServiceUnavailable, Response{Error: "no leader elected"})
+	}
+	return c.Redirect(http.StatusTemporaryRedirect, "http://"+addr+c.Request().URL.RequestURI())
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+
+	"github.com/trenton42/miniredis/internal/cluster"
+	"github.com/trenton42/miniredis/internal/pubsub"
 )
 
 // store interface that describes methods for reading and writing to a data store
@@ -20,6 +24,9 @@ type store interface {
 	MapSet(string, string, string) error
 	MapDelete(string, string) error
 	GetIndex(string) []string
+	Expire(string, time.Duration) error
+	Persist(string) error
+	TTL(string) (time.Duration, error)
 }
 
 // Response holds the reply from the server
@@ -37,15 +44,30 @@ type Request struct {
 
 // Server holds all methods that will listen and reply over an HTTP interface
 type Server struct {
-	s      store
-	router *echo.Echo
+	s       store
+	cluster *cluster.Cluster
+	broker  *pubsub.Broker
+	router  *echo.Echo
+}
+
+// notifier is implemented by any store that can be wired up to publish its
+// keyspace events, currently only *storage.Storage. A plain type assertion
+// against it keeps the store interface itself free of pub/sub concerns.
+type notifier interface {
+	SetNotifier(*pubsub.Broker)
 }
 
-// New creates a server instance. It takes an instance that fulfils interface store
+// New creates a server instance. It takes an instance that fulfils interface
+// store. If s also implements notifier (as *storage.Storage does), its
+// keyspace events are wired up to the new Server's broker automatically.
 func New(s store) *Server {
 	var server Server
 	server.s = s
 	server.router = echo.New()
+	server.broker = pubsub.New()
+	if n, ok := s.(notifier); ok {
+		n.SetNotifier(server.broker)
+	}
 	return &server
 }
 
@@ -56,14 +78,32 @@ func (s *Server) Serve(port int) {
 	s.router.PUT("/:key", s.Set)
 	s.router.DELETE("/:key", s.Delete)
 	s.router.POST("/:key", s.Cmd)
+	s.router.GET("/_subscribe", s.Subscribe)
+	s.router.GET("/_events", s.Events)
+	if s.cluster != nil {
+		s.router.POST("/_cluster/join", s.ClusterJoin)
+		s.router.POST("/_cluster/leave", s.ClusterLeave)
+		s.router.GET("/_cluster/status", s.ClusterStatus)
+	}
 	s.router.Logger.Fatal(s.router.Start(fmt.Sprintf(":%d", port)))
 }
 
-// Get the value of a key
+// Get the value of a key. When running clustered, ?consistency=linearizable
+// routes the read through a Raft read-index barrier first; any other value
+// (the default) serves it straight from local storage, which may be stale.
 func (s *Server) Get(c echo.Context) error {
-	val, err := s.s.Get(c.Param("key"))
+	var val []byte
+	var err error
+	if s.cluster != nil && c.QueryParam("consistency") == "linearizable" {
+		val, err = s.cluster.Get(c.Param("key"), true)
+	} else {
+		val, err = s.s.Get(c.Param("key"))
+	}
 	var r Response
 	if err != nil {
+		if err == cluster.ErrNotLeader {
+			return s.redirectToLeader(c)
+		}
 		r.Error = err.Error()
 		return c.JSON(http.StatusBadRequest, r)
 	}
@@ -76,12 +116,21 @@ func (s *Server) Set(c echo.Context) error {
 	var request Request
 	var r Response
 	c.Bind(&request)
-	err := s.s.Set(c.Param("key"), request.Value)
+	key := c.Param("key")
+	var err error
+	if s.cluster != nil {
+		err = s.cluster.Set(key, request.Value)
+	} else {
+		err = s.s.Set(key, request.Value)
+	}
 	if err != nil {
+		if err == cluster.ErrNotLeader {
+			return s.redirectToLeader(c)
+		}
 		r.Error = err.Error()
 		return c.JSON(http.StatusBadRequest, r)
 	}
-	r.Value = []byte(fmt.Sprintf("\"%s\"", c.Param("key")))
+	r.Value = []byte(fmt.Sprintf("\"%s\"", key))
 	val, er := json.Marshal(r)
 	fmt.Printf("%s, %v", val, er)
 	return c.JSON(http.StatusOK, r)
@@ -89,7 +138,17 @@ func (s *Server) Set(c echo.Context) error {
 
 // Delete removes a key
 func (s *Server) Delete(c echo.Context) error {
-	s.s.Delete(c.Param("key"))
+	key := c.Param("key")
+	if s.cluster != nil {
+		if err := s.cluster.Delete(key); err != nil {
+			if err == cluster.ErrNotLeader {
+				return s.redirectToLeader(c)
+			}
+			return c.JSON(http.StatusBadRequest, Response{Error: err.Error()})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+	s.s.Delete(key)
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -103,7 +162,7 @@ func (s *Server) Cmd(c echo.Context) error {
 	var res string
 	c.Bind(&request)
 	key := c.Param("key")
-	if request.Cmd == "append" || request.Cmd == "mapset" {
+	if request.Cmd == "append" || request.Cmd == "mapset" || request.Cmd == "publish" {
 		var ok bool
 		value, ok = request.Value.(string)
 		if !ok {
@@ -113,10 +172,18 @@ func (s *Server) Cmd(c echo.Context) error {
 	}
 	switch request.Cmd {
 	case "append":
-		err = s.s.Append(key, value)
+		if s.cluster != nil {
+			err = s.cluster.Append(key, value)
+		} else {
+			err = s.s.Append(key, value)
+		}
 		break
 	case "pop":
-		res, err = s.s.Pop(key)
+		if s.cluster != nil {
+			res, err = s.cluster.Pop(key)
+		} else {
+			res, err = s.s.Pop(key)
+		}
 		if err == nil {
 			r.Value, err = json.Marshal(res)
 		}
@@ -128,19 +195,64 @@ func (s *Server) Cmd(c echo.Context) error {
 		}
 		break
 	case "mapset":
-		err = s.s.MapSet(key, request.Key, value)
+		if s.cluster != nil {
+			err = s.cluster.MapSet(key, request.Key, value)
+		} else {
+			err = s.s.MapSet(key, request.Key, value)
+		}
 		break
 	case "mapdelete":
-		err = s.s.MapDelete(key, request.Key)
+		if s.cluster != nil {
+			err = s.cluster.MapDelete(key, request.Key)
+		} else {
+			err = s.s.MapDelete(key, request.Key)
+		}
 		break
 	case "index":
 		index := s.s.GetIndex(request.Key)
 		r.Value, err = json.Marshal(index)
 		break
+	case "expire":
+		seconds, ok := request.Value.(float64)
+		if !ok {
+			r.Error = "value must be a number of seconds"
+			return c.JSON(http.StatusBadRequest, r)
+		}
+		ttl := time.Duration(seconds * float64(time.Second))
+		if s.cluster != nil {
+			err = s.cluster.Expire(key, ttl)
+		} else {
+			err = s.s.Expire(key, ttl)
+		}
+		break
+	case "persist":
+		if s.cluster != nil {
+			err = s.cluster.Persist(key)
+		} else {
+			err = s.s.Persist(key)
+		}
+		break
+	case "ttl":
+		var ttl time.Duration
+		ttl, err = s.s.TTL(key)
+		if err == nil {
+			r.Value, err = json.Marshal(ttl.Seconds())
+		}
+		break
+	case "publish":
+		// Unlike expire/persist above, this is handled locally even when
+		// s.cluster != nil: the broker is per-node, so a PUBLISH only
+		// reaches subscribers connected to the node it was sent to.
+		ev := s.broker.Publish(key, pubsub.OpMessage, value)
+		r.Value, err = json.Marshal(ev.Seq)
+		break
 	default:
 		err = fmt.Errorf("no command specified")
 	}
 	if err != nil {
+		if err == cluster.ErrNotLeader {
+			return s.redirectToLeader(c)
+		}
 		r.Error = err.Error()
 		rcode = http.StatusBadRequest
 	}
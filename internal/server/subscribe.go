@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+
+	"github.com/trenton42/miniredis/internal/pubsub"
+)
+
+// longPollTimeout bounds how long a single GET /_events request blocks
+// waiting for a matching event before returning an empty page. That page's
+// cursor still advances past any non-matching events logged meanwhile.
+const longPollTimeout = 30 * time.Second
+
+// upgrader upgrades GET /_subscribe to a WebSocket connection. Origin
+// checking is left to whatever reverse proxy fronts this in production,
+// the same trust boundary the rest of the HTTP API assumes.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscriptionPattern returns the ?pattern= query param, defaulting to "*"
+// (every channel) when it is omitted.
+func subscriptionPattern(c echo.Context) string {
+	if p := c.QueryParam("pattern"); p != "" {
+		return p
+	}
+	return "*"
+}
+
+// Subscribe upgrades to a WebSocket connection and streams every broker
+// Event whose channel matches ?pattern= (exact or glob) as JSON, one event
+// per text frame, until the client disconnects.
+func (s *Server) Subscribe(c echo.Context) error {
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sub := s.broker.Subscribe(subscriptionPattern(c))
+	defer sub.Close()
+
+	// The client never sends anything meaningful over this connection, but
+	// we still need to notice when it closes the socket; ReadMessage blocks
+	// until then.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case ev := <-sub.Events():
+			if err := conn.WriteJSON(ev); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// EventsResponse is the body of GET /_events.
+type EventsResponse struct {
+	Events []pubsub.Event `json:"events"`
+	Cursor uint64         `json:"cursor"`
+}
+
+// Events long-polls for keyspace/channel events: it blocks up to
+// longPollTimeout waiting for at least one event newer than ?since= that
+// matches ?pattern=, then returns whatever accumulated plus a cursor the
+// caller should pass as ?since= on its next call.
+func (s *Server) Events(c echo.Context) error {
+	since, _ := strconv.ParseUint(c.QueryParam("since"), 10, 64)
+	events, cursor := s.broker.Wait(subscriptionPattern(c), since, longPollTimeout)
+	return c.JSON(http.StatusOK, EventsResponse{Events: events, Cursor: cursor})
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trenton42/miniredis/internal/pubsub"
+)
+
+func TestSetNotifierPublishesMutations(t *testing.T) {
+	s := New()
+	defer s.Close()
+	b := pubsub.New()
+	s.SetNotifier(b)
+	sub := b.Subscribe("a")
+	defer sub.Close()
+
+	s.Set("a", "one")
+	s.Delete("a")
+
+	select {
+	case ev := <-sub.Events():
+		if ev.Op != pubsub.OpSet || ev.Channel != "a" {
+			t.Errorf("got %+v, want set event for key a", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for set event")
+	}
+	select {
+	case ev := <-sub.Events():
+		if ev.Op != pubsub.OpDel || ev.Channel != "a" {
+			t.Errorf("got %+v, want del event for key a", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for del event")
+	}
+}
+
+func TestExpireFiresExpiredEvent(t *testing.T) {
+	s := New()
+	defer s.Close()
+	b := pubsub.New()
+	s.SetNotifier(b)
+	sub := b.Subscribe("a")
+	defer sub.Close()
+
+	s.Set("a", "one")
+	if err := s.Expire("a", time.Millisecond); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Events():
+			if ev.Op == pubsub.OpExpired {
+				return
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for expired event")
+		}
+	}
+}
+
+func TestNotifierNilByDefault(t *testing.T) {
+	s := New()
+	defer s.Close()
+	// Set and Delete must not panic when no notifier has been wired up.
+	s.Set("a", "one")
+	s.Delete("a")
+}
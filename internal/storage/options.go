@@ -0,0 +1,43 @@
+package storage
+
+import "time"
+
+// SyncMode controls how aggressively the write-ahead log is flushed to
+// disk once a record has been appended to it.
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs the WAL after every mutation. Slowest, safest.
+	SyncAlways SyncMode = iota
+	// SyncInterval fsyncs the WAL on a fixed timer, batching writes between
+	// ticks. A crash can lose up to one tick's worth of mutations.
+	SyncInterval
+	// SyncNone never explicitly fsyncs and relies on the OS to flush the
+	// page cache eventually.
+	SyncNone
+)
+
+// Options configures the durability behaviour of a Storage opened with
+// Open.
+type Options struct {
+	// Sync selects when the WAL is fsync'd.
+	Sync SyncMode
+	// SyncEvery is the fsync period used when Sync is SyncInterval.
+	SyncEvery time.Duration
+	// SnapshotEvery is how often the background goroutine takes a snapshot
+	// and truncates the WAL, independent of its size.
+	SnapshotEvery time.Duration
+	// MaxWALBytes triggers an out-of-band snapshot as soon as the WAL grows
+	// past it, rather than waiting for the next SnapshotEvery tick.
+	MaxWALBytes int64
+}
+
+// DefaultOptions returns the Options used when none are supplied explicitly.
+func DefaultOptions() Options {
+	return Options{
+		Sync:          SyncInterval,
+		SyncEvery:     200 * time.Millisecond,
+		SnapshotEvery: 30 * time.Second,
+		MaxWALBytes:   4 << 20, // 4MiB
+	}
+}
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const walFileName = "wal.log"
+
+// snapshotState is the gob-encoded shape written to snapshot.N.gob. Item
+// can't be gob-encoded directly since its value fields are unexported, so
+// itemSnapshot mirrors it with exported fields instead. Expires holds the
+// same TTL deadlines as s.expiryIndex (key -> Unix nanosecond deadline), so
+// a snapshot - which truncates the WAL a TTL might otherwise be recovered
+// from - doesn't silently make every key persistent again.
+type snapshotState struct {
+	Items   map[string]itemSnapshot
+	Index   []string
+	Expires map[string]int64
+	// WALOffset is the byte length of wal.log at the moment this snapshot
+	// was taken, i.e. every record up to this offset is already reflected
+	// in Items/Index/Expires. replayWAL starts from here instead of 0 so a
+	// crash between the snapshot becoming durable and the WAL being
+	// truncated doesn't re-apply records the snapshot already contains.
+	WALOffset int64
+}
+
+type itemSnapshot struct {
+	Type   string
+	String string
+	List   []string
+	Map    map[string]string
+}
+
+func toSnapshot(i *Item) itemSnapshot {
+	return itemSnapshot{Type: i.Type, String: i.stringValue, List: i.listValue, Map: i.mapValue}
+}
+
+func fromSnapshot(is itemSnapshot) *Item {
+	return &Item{Type: is.Type, stringValue: is.String, listValue: is.List, mapValue: is.Map}
+}
+
+// Open creates or reopens a durable Storage rooted at dir. It loads the
+// newest snapshot found in dir (if any), replays the wal.log records
+// written after it, and leaves the WAL open for further appends. A
+// background goroutine then takes over periodic fsyncs and snapshots.
+func Open(dir string, opts Options) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("storage: %v", err)
+	}
+
+	s := New()
+	s.dir = dir
+	s.opts = opts
+	s.snapshotCh = make(chan struct{}, 1)
+
+	seq, walOffset, err := s.loadLatestSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshotSeq = seq
+
+	if err := s.replayWAL(walOffset); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %v", err)
+	}
+	s.walFile = f
+	if fi, err := f.Stat(); err == nil {
+		s.walBytes = fi.Size()
+	}
+
+	s.stopCh = make(chan struct{})
+	s.wg.Add(1)
+	go s.maintain()
+
+	return s, nil
+}
+
+// loadLatestSnapshot finds the highest-numbered snapshot.N.gob in s.dir and
+// loads it into s.items/s.index. It returns the snapshot's sequence number
+// and the WAL offset recorded alongside it (both 0 with no error if no
+// snapshot exists yet).
+func (s *Storage) loadLatestSnapshot() (int, int64, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("storage: %v", err)
+	}
+	best := 0
+	var bestName string
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "snapshot.%d.gob", &n); err != nil {
+			continue
+		}
+		if n > best {
+			best = n
+			bestName = e.Name()
+		}
+	}
+	if bestName == "" {
+		return 0, 0, nil
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, bestName))
+	if err != nil {
+		return 0, 0, fmt.Errorf("storage: %v", err)
+	}
+	defer f.Close()
+
+	var state snapshotState
+	if err := gob.NewDecoder(f).Decode(&state); err != nil {
+		return 0, 0, fmt.Errorf("storage: %v", err)
+	}
+	items := make(map[string]*Item, len(state.Items))
+	for k, is := range state.Items {
+		items[k] = fromSnapshot(is)
+	}
+	s.items = items
+	s.index = sort.StringSlice(append([]string(nil), state.Index...))
+	if s.restoreExpiriesLocked(state.Expires) {
+		s.ensureReaper()
+	}
+	return best, state.WALOffset, nil
+}
+
+// replayWAL applies every well-formed record in wal.log (if it exists) to
+// the freshly loaded snapshot state, then truncates the file to drop any
+// torn record left behind by a crash mid-write.
+//
+// from is the WAL offset recorded by the snapshot just loaded: everything
+// before it is already reflected in that snapshot. If wal.log is shorter
+// than from, the snapshot's own truncate already ran to completion and
+// every byte in the (now short) file is new since; replay starts at 0 in
+// that case instead of skipping past it.
+func (s *Storage) replayWAL(from int64) error {
+	path := filepath.Join(s.dir, walFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("storage: %v", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("storage: %v", err)
+	}
+	offset := from
+	if offset > fi.Size() {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("storage: %v", err)
+	}
+
+	for {
+		rec, err := readWALRecord(f)
+		if err != nil {
+			// io.EOF means a clean end of log; anything else means the
+			// last record was torn or corrupt - either way, replay stops
+			// here and the file gets truncated back to the last good frame.
+			break
+		}
+		s.applyRecord(rec)
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			break
+		}
+		offset = pos
+	}
+	return f.Truncate(offset)
+}
+
+// WriteSnapshot gob-encodes the full current state to w, using the same
+// format as the snapshot.N.gob files Open/Close maintain. It is exported so
+// other durability layers - namely internal/cluster's Raft FSM - can reuse
+// it instead of inventing a second on-disk format.
+func (s *Storage) WriteSnapshot(w io.Writer) error {
+	s.RLock()
+	defer s.RUnlock()
+	state := snapshotState{Items: make(map[string]itemSnapshot, len(s.items)), Index: append([]string(nil), s.index...), Expires: s.snapshotExpiresLocked()}
+	for k, i := range s.items {
+		state.Items[k] = toSnapshot(i)
+	}
+	return gob.NewEncoder(w).Encode(&state)
+}
+
+// ReadSnapshot replaces the current in-memory state with the snapshot
+// decoded from r, as written by WriteSnapshot.
+func (s *Storage) ReadSnapshot(r io.Reader) error {
+	var state snapshotState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("storage: %v", err)
+	}
+	items := make(map[string]*Item, len(state.Items))
+	for k, is := range state.Items {
+		items[k] = fromSnapshot(is)
+	}
+	s.Lock()
+	s.items = items
+	s.index = sort.StringSlice(append([]string(nil), state.Index...))
+	hadExpiries := s.restoreExpiriesLocked(state.Expires)
+	s.Unlock()
+	if hadExpiries {
+		s.ensureReaper()
+	}
+	return nil
+}
+
+// snapshot writes the full current state, tagged with the current WAL
+// length (WALOffset), to a new snapshot.N.gob, then truncates the WAL: once
+// the snapshot is durable on disk, every record written before it is
+// redundant. A crash between the two steps leaves the WAL untruncated, but
+// replayWAL uses WALOffset to skip those now-redundant records rather than
+// re-applying them.
+func (s *Storage) snapshot() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.walFile == nil {
+		return nil
+	}
+
+	seq := s.snapshotSeq + 1
+	tmp := filepath.Join(s.dir, fmt.Sprintf("snapshot.%d.gob.tmp", seq))
+	final := filepath.Join(s.dir, fmt.Sprintf("snapshot.%d.gob", seq))
+
+	state := snapshotState{Items: make(map[string]itemSnapshot, len(s.items)), Index: append([]string(nil), s.index...), Expires: s.snapshotExpiresLocked(), WALOffset: s.walBytes}
+	for k, i := range s.items {
+		state.Items[k] = toSnapshot(i)
+	}
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+	if err := gob.NewEncoder(f).Encode(&state); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+
+	if err := s.walFile.Truncate(0); err != nil {
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+	if _, err := s.walFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("storage: snapshot: %v", err)
+	}
+	s.walBytes = 0
+	s.snapshotSeq = seq
+	s.pruneSnapshots(seq)
+	return nil
+}
+
+// pruneSnapshots removes every snapshot older than keep: the WAL only ever
+// holds records written after the latest snapshot, so anything earlier is
+// dead weight.
+func (s *Storage) pruneSnapshots(keep int) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "snapshot.%d.gob", &n); err != nil {
+			continue
+		}
+		if n < keep {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+// maintain runs in the background for the lifetime of a Storage opened with
+// Open, taking periodic snapshots and, in SyncInterval mode, periodic
+// fsyncs, until Close signals it to stop.
+func (s *Storage) maintain() {
+	defer s.wg.Done()
+
+	snapshotEvery := s.opts.SnapshotEvery
+	if snapshotEvery <= 0 {
+		snapshotEvery = 30 * time.Second
+	}
+	snapshotTicker := time.NewTicker(snapshotEvery)
+	defer snapshotTicker.Stop()
+
+	var syncC <-chan time.Time
+	if s.opts.Sync == SyncInterval {
+		interval := s.opts.SyncEvery
+		if interval <= 0 {
+			interval = 200 * time.Millisecond
+		}
+		syncTicker := time.NewTicker(interval)
+		defer syncTicker.Stop()
+		syncC = syncTicker.C
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-snapshotTicker.C:
+			s.snapshot()
+		case <-s.snapshotCh:
+			s.snapshot()
+		case <-syncC:
+			s.Lock()
+			if s.walFile != nil {
+				s.walFile.Sync()
+			}
+			s.Unlock()
+		}
+	}
+}
+
+// Close stops the TTL reaper (if any key ever had a TTL) and, for Storage
+// created with Open, stops the background maintenance goroutine, takes a
+// final snapshot to checkpoint all pending mutations, and closes the WAL
+// file. The snapshot/WAL steps are a no-op for Storage created with New.
+func (s *Storage) Close() error {
+	s.stopReaper()
+	if s.walFile == nil {
+		return nil
+	}
+	close(s.stopCh)
+	s.wg.Wait()
+
+	if err := s.snapshot(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if err := s.walFile.Sync(); err != nil {
+		return err
+	}
+	return s.walFile.Close()
+}
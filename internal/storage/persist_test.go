@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.Sync = SyncAlways
+	opts.SnapshotEvery = time.Hour
+	opts.MaxWALBytes = 1 << 20
+	return opts
+}
+
+func TestOpenRecoversFromWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikeystore")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Set("a", "hello")
+	s.Append("list", "one")
+	s.Append("list", "two")
+	s.MapSet("m", "k", "v")
+	s.Delete("a")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	if _, err := reopened.Get("a"); err == nil {
+		t.Error("expected a to stay deleted after recovery")
+	}
+	val, err := reopened.MapGet("m", "k")
+	if err != nil || val != "v" {
+		t.Errorf("expected m.k == v, got %q err %v", val, err)
+	}
+	listRaw, err := reopened.Get("list")
+	if err != nil {
+		t.Fatalf("Get list: %v", err)
+	}
+	if string(listRaw) != `["one","two"]` {
+		t.Errorf("expected list to survive recovery, got %s", listRaw)
+	}
+}
+
+func TestOpenReplaysWALWithoutClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikeystore")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Set("a", "hello")
+	s.Append("list", "one")
+	s.Append("list", "two")
+	// Deliberately no Close: this simulates a process killed mid-stream,
+	// with mutations fsynced to the WAL (SyncAlways) but never snapshotted.
+
+	reopened, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("reopen without close: %v", err)
+	}
+	defer reopened.Close()
+
+	val, err := reopened.Get("a")
+	if err != nil || string(val) != `"hello"` {
+		t.Errorf("expected a to survive a kill+reopen, got %s err %v", val, err)
+	}
+	listRaw, err := reopened.Get("list")
+	if err != nil || string(listRaw) != `["one","two"]` {
+		t.Errorf("expected list to survive a kill+reopen, got %s err %v", listRaw, err)
+	}
+}
+
+// TestOpenSkipsRecordsCoveredBySnapshot reproduces a crash between a
+// snapshot becoming durable (os.Rename) and the WAL being truncated: it
+// hand-crafts that on-disk state (snapshot containing "list":["one"], WAL
+// still holding the untruncated Append that produced it) and checks replay
+// doesn't re-apply the Append on top of the snapshot.
+func TestOpenSkipsRecordsCoveredBySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikeystore")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Append("list", "one")
+	walOffset := s.walBytes
+
+	state := snapshotState{
+		Items:   map[string]itemSnapshot{"list": toSnapshot(&Item{Type: "list", listValue: []string{"one"}})},
+		Index:   []string{"list"},
+		Expires: map[string]int64{},
+		// The WAL at this point still contains exactly the Append above,
+		// matching the crash window where the rename succeeded but
+		// Truncate(0) never ran.
+		WALOffset: walOffset,
+	}
+	f, err := os.Create(filepath.Join(dir, "snapshot.1.gob"))
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+	if err := gob.NewEncoder(f).Encode(&state); err != nil {
+		t.Fatalf("encode snapshot: %v", err)
+	}
+	f.Close()
+
+	// Stop the background goroutine and release the WAL file without
+	// calling Close, which would take its own snapshot and truncate the
+	// WAL, erasing the crash window this test is reproducing.
+	s.stopReaper()
+	close(s.stopCh)
+	s.wg.Wait()
+	s.walFile.Close()
+
+	reopened, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	listRaw, err := reopened.Get("list")
+	if err != nil {
+		t.Fatalf("Get list: %v", err)
+	}
+	if string(listRaw) != `["one"]` {
+		t.Errorf("expected the untruncated WAL Append not to be replayed on top of the snapshot, got %s", listRaw)
+	}
+}
+
+func TestOpenDiscardsTornTailRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikeystore")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Set("a", "hello")
+	s.Set("b", "world")
+	walPath := s.walFile.Name()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	if _, err := f.Write([]byte{0x01, 0x02, 0x03}); err != nil {
+		t.Fatalf("write garbage: %v", err)
+	}
+	f.Close()
+
+	reopened, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("reopen after corruption: %v", err)
+	}
+	defer reopened.Close()
+
+	val, err := reopened.Get("a")
+	if err != nil || string(val) != `"hello"` {
+		t.Errorf("expected a to survive, got %s err %v", val, err)
+	}
+	val, err = reopened.Get("b")
+	if err != nil || string(val) != `"world"` {
+		t.Errorf("expected b to survive, got %s err %v", val, err)
+	}
+}
+
+func TestOpenRecoversTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikeystore")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.SetWithTTL("a", "hello", time.Hour); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	s.Set("b", "world")
+	if err := s.Expire("b", time.Hour); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if err := s.SetWithTTL("c", "temp", time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	for _, key := range []string{"a", "b"} {
+		ttl, err := reopened.TTL(key)
+		if err != nil {
+			t.Errorf("TTL(%s): %v", key, err)
+		}
+		if ttl <= 0 || ttl > time.Hour {
+			t.Errorf("TTL(%s) = %v, want something in (0, 1h]", key, ttl)
+		}
+	}
+	if _, err := reopened.Get("c"); err == nil {
+		t.Error("expected key that expired before Close to not survive recovery")
+	}
+}
+
+func TestSnapshotTruncatesWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikeystore")
+	if err != nil {
+		t.Fatalf("tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Set("a", "hello")
+	if err := s.snapshot(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if s.walBytes != 0 {
+		t.Errorf("expected WAL to be truncated after snapshot, got %d bytes", s.walBytes)
+	}
+	s.Close()
+
+	reopened, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	val, err := reopened.Get("a")
+	if err != nil || string(val) != `"hello"` {
+		t.Errorf("expected a to survive snapshot+reopen, got %s err %v", val, err)
+	}
+}
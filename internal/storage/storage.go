@@ -3,11 +3,15 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	glob "github.com/ryanuber/go-glob"
+
+	"github.com/trenton42/miniredis/internal/pubsub"
 )
 
 // Item holds a specific piece of data
@@ -24,6 +28,52 @@ type Storage struct {
 	sync.RWMutex
 	items map[string]*Item
 	index sort.StringSlice
+
+	// The fields below are only set when the store was created with Open.
+	// Storage returned by New is purely in-memory and walFile stays nil, so
+	// every writeWAL call below is a no-op for it.
+	dir         string
+	opts        Options
+	walFile     *os.File
+	walBytes    int64
+	snapshotSeq int
+	snapshotCh  chan struct{}
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+
+	// expiry and expiryIndex implement TTL support: expiry is a min-heap
+	// ordered by expiresAt so the reaper goroutine (started lazily by
+	// ensureReaper) can wake precisely at the next expiration, and
+	// expiryIndex is the key->entry lookup heap.Interface can't give us on
+	// its own.
+	expiry      expiryHeap
+	expiryIndex map[string]*expiryEntry
+	reaperOnce  sync.Once
+	reaperStop  chan struct{}
+	reaperWG    sync.WaitGroup
+
+	// notify receives a keyspace event for every mutation below, tagged
+	// with the affected key. It is nil until SetNotifier is called, so
+	// Storage used without pub/sub never pays for it.
+	notify *pubsub.Broker
+}
+
+// SetNotifier wires b as the destination for this Storage's keyspace
+// events (set, del, append, pop, mapset, mapdelete, expired). Pass nil to
+// stop publishing.
+func (s *Storage) SetNotifier(b *pubsub.Broker) {
+	s.Lock()
+	defer s.Unlock()
+	s.notify = b
+}
+
+// publish fires a keyspace event for key if a notifier is wired. Callers
+// must hold s.Lock.
+func (s *Storage) publish(op, key, value string) {
+	if s.notify == nil {
+		return
+	}
+	s.notify.Publish(key, op, value)
 }
 
 // New initializes a string store for use
@@ -31,11 +81,13 @@ func New() *Storage {
 	var s Storage
 	s.items = make(map[string]*Item)
 	s.index = make([]string, 0)
+	s.expiryIndex = make(map[string]*expiryEntry)
 	return &s
 }
 
 // checkkey does some basic error checking to see if a key exists and if it is the correct type
 func (s *Storage) checkkey(key string, expectedType string, create bool) (*Item, error) {
+	s.expiredLocked(key)
 	i, ok := s.items[key]
 	if !ok {
 		if !create {
@@ -78,8 +130,9 @@ func (s *Storage) updateIndex(key string, add bool) {
 
 // Get returns an item as []byte
 func (s *Storage) Get(key string) ([]byte, error) {
-	s.RLock()
-	defer s.RUnlock()
+	s.Lock()
+	defer s.Unlock()
+	s.expiredLocked(key)
 	i, ok := s.items[key]
 	if !ok {
 		return nil, fmt.Errorf("item does not exist")
@@ -97,6 +150,24 @@ func (s *Storage) Get(key string) ([]byte, error) {
 
 // Set puts an item into storage
 func (s *Storage) Set(key string, value interface{}) error {
+	return s.setWithExpiry(key, value, time.Time{})
+}
+
+// SetWithTTL puts an item into storage the same way Set does, but also
+// schedules it to expire (both lazily, on the next access, and actively,
+// via the background reaper) after ttl elapses.
+func (s *Storage) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := s.setWithExpiry(key, value, time.Now().Add(ttl)); err != nil {
+		return err
+	}
+	s.ensureReaper()
+	return nil
+}
+
+// setWithExpiry backs both Set and SetWithTTL. expiresAt is the zero Time
+// for a plain Set, which clears any TTL the key previously had, matching
+// Redis's SET-without-EX semantics.
+func (s *Storage) setWithExpiry(key string, value interface{}, expiresAt time.Time) error {
 	s.Lock()
 	defer s.Unlock()
 	var i Item
@@ -129,16 +200,37 @@ func (s *Storage) Set(key string, value interface{}) error {
 		s.updateIndex(key, true)
 	}
 	s.items[key] = &i
+	if expiresAt.IsZero() {
+		s.removeExpiryLocked(key)
+	} else {
+		s.setExpiryLocked(key, expiresAt)
+	}
+	if s.walFile != nil {
+		var expiresAtUnix int64
+		if !expiresAt.IsZero() {
+			expiresAtUnix = expiresAt.UnixNano()
+		}
+		s.writeWAL(walRecord{Op: opSet, Key: key, Type: i.Type, Value: i.stringValue, List: i.listValue, Map: i.mapValue, ExpiresAt: expiresAtUnix})
+	}
+	s.publish(pubsub.OpSet, key, i.stringValue)
 	return nil
 }
 
 // Delete removes a value stored at key
 func (s *Storage) Delete(key string) {
 	s.Lock()
-	if _, ok := s.items[key]; ok {
+	_, existed := s.items[key]
+	if existed {
 		s.updateIndex(key, false)
 	}
 	delete(s.items, key)
+	s.removeExpiryLocked(key)
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opDelete, Key: key})
+	}
+	if existed {
+		s.publish(pubsub.OpDel, key, "")
+	}
 	s.Unlock()
 }
 
@@ -155,6 +247,10 @@ func (s *Storage) Append(key string, value string) error {
 	}
 	i.listValue = append(i.listValue, value)
 	s.items[key] = i
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opAppend, Key: key, Value: value})
+	}
+	s.publish(pubsub.OpAppend, key, value)
 	return nil
 }
 
@@ -171,13 +267,102 @@ func (s *Storage) Pop(key string) (string, error) {
 	}
 	var value string
 	value, i.listValue = i.listValue[len(i.listValue)-1], i.listValue[:len(i.listValue)-1]
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opPop, Key: key})
+	}
+	s.publish(pubsub.OpPop, key, value)
+	return value, nil
+}
+
+// Lpush pushes a value onto the front of a list, or returns error if the type is not a list
+func (s *Storage) Lpush(key string, value string) error {
+	s.Lock()
+	defer s.Unlock()
+	i, err := s.checkkey(key, "list", true)
+	if err != nil {
+		return err
+	}
+	i.listValue = append([]string{value}, i.listValue...)
+	s.items[key] = i
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opLpush, Key: key, Value: value})
+	}
+	return nil
+}
+
+// Lpop removes a value from the front of a list and returns it, or returns error if the type is not a list or the list is empty
+func (s *Storage) Lpop(key string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	i, err := s.checkkey(key, "list", false)
+	if err != nil {
+		return "", err
+	}
+	if len(i.listValue) == 0 {
+		return "", fmt.Errorf("list is empty")
+	}
+	value := i.listValue[0]
+	i.listValue = i.listValue[1:]
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opLpop, Key: key})
+	}
 	return value, nil
 }
 
+// Llen returns the length of a list, or an error if the key holds a non-list value. A missing key has length 0.
+func (s *Storage) Llen(key string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.expiredLocked(key)
+	i, ok := s.items[key]
+	if !ok {
+		return 0, nil
+	}
+	if i.Type != "list" {
+		return 0, fmt.Errorf("type %s is not list", i.Type)
+	}
+	return len(i.listValue), nil
+}
+
+// Lrange returns the list elements between start and stop inclusive, Redis-style: negative
+// indices count back from the end of the list, and out-of-range bounds are clamped rather
+// than erroring. A missing key returns an empty slice.
+func (s *Storage) Lrange(key string, start int, stop int) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.expiredLocked(key)
+	i, ok := s.items[key]
+	if !ok {
+		return []string{}, nil
+	}
+	if i.Type != "list" {
+		return nil, fmt.Errorf("type %s is not list", i.Type)
+	}
+	n := len(i.listValue)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if n == 0 || start > stop {
+		return []string{}, nil
+	}
+	result := make([]string, stop-start+1)
+	copy(result, i.listValue[start:stop+1])
+	return result, nil
+}
+
 // MapGet returns a specific key from a map type
 func (s *Storage) MapGet(key string, mkey string) (string, error) {
-	s.RLock()
-	defer s.RUnlock()
+	s.Lock()
+	defer s.Unlock()
 	i, err := s.checkkey(key, "map", false)
 	if err != nil {
 		return "", err
@@ -205,6 +390,10 @@ func (s *Storage) MapSet(key string, mkey string, value string) error {
 	}
 	i.mapValue[mkey] = value
 	s.items[key] = i
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opMapSet, Key: key, MKey: mkey, Value: value})
+	}
+	s.publish(pubsub.OpMapSet, key, value)
 	return nil
 }
 
@@ -221,30 +410,50 @@ func (s *Storage) MapDelete(key string, mkey string) error {
 	}
 	delete(i.mapValue, mkey)
 	s.items[key] = i
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opMapDelete, Key: key, MKey: mkey})
+	}
+	s.publish(pubsub.OpMapDelete, key, mkey)
 	return nil
 }
 
-// GetIndex returns a slice of the index based on a globbed key.
+// GetIndex returns a slice of the index based on a globbed key. Keys whose
+// TTL has expired are treated as absent and lazily deleted, same as Get.
 func (s *Storage) GetIndex(search string) []string {
-	// Match everything, so return full index
-	if search == "*" {
-		return s.index
-	}
-	parts := strings.Split(search, "*")
-	// There is no wildcard at all, so only return the index key (if it exists)
-	if len(parts) == 1 {
-		index := s.index.Search(search)
-		if s.index[index] != search {
-			return make([]string, 0)
+	s.Lock()
+	defer s.Unlock()
+
+	var candidates []string
+	switch {
+	case search == "*":
+		// Match everything, so return the full index
+		candidates = append([]string(nil), s.index...)
+	default:
+		parts := strings.Split(search, "*")
+		if len(parts) == 1 {
+			// There is no wildcard at all, so only return the index key (if it exists)
+			index := s.index.Search(search)
+			if index == len(s.index) || s.index[index] != search {
+				candidates = make([]string, 0)
+			} else {
+				candidates = []string{s.index[index]}
+			}
+		} else {
+			// Now we are going in for a full glob search
+			for _, val := range s.index {
+				if glob.Glob(search, val) {
+					candidates = append(candidates, val)
+				}
+			}
 		}
-		return []string{s.index[index]}
 	}
-	// Now we are going in for a full glob search
-	result := make([]string, 0)
-	for _, val := range s.index {
-		if glob.Glob(search, val) {
-			result = append(result, val)
+
+	result := make([]string, 0, len(candidates))
+	for _, key := range candidates {
+		if s.expiredLocked(key) {
+			continue
 		}
+		result = append(result, key)
 	}
 	return result
 }
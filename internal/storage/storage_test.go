@@ -223,6 +223,134 @@ func TestPop(t *testing.T) {
 
 }
 
+func TestLpush(t *testing.T) {
+	s := New()
+	s.Set("a", []string{})
+	s.Set("b", []string{"z"})
+	s.Set("c", "string")
+
+	var tests = []struct {
+		key      string
+		push     string
+		hasError bool
+		value    []string
+	}{
+		{"a", "b", false, []string{"b"}},
+		{"missing", "b", false, []string{"b"}},
+		{"b", "b", false, []string{"b", "z"}},
+		{"c", "b", true, []string{}},
+	}
+
+	for i, test := range tests {
+		err := s.Lpush(test.key, test.push)
+		if (err != nil) != test.hasError {
+			t.Errorf("[run %d] Error missmatch: Expected: %t, had: %t", i, test.hasError, (err != nil))
+		}
+		if err != nil {
+			continue
+		}
+
+		if !checkSlice(s.items[test.key].listValue, test.value) {
+			t.Errorf("[run %d] Values do not match: %v != %v", i, test.value, s.items[test.key].listValue)
+		}
+	}
+}
+
+func TestLpop(t *testing.T) {
+	s := New()
+	s.Set("a", []string{"a", "b", "c"})
+	s.Set("b", []string{})
+	s.Set("c", "string")
+
+	var tests = []struct {
+		key      string
+		ret      string
+		hasError bool
+		value    []string
+	}{
+		{"a", "a", false, []string{"b", "c"}},
+		{"missing", "", true, []string{}},
+		{"b", "", true, []string{}},
+		{"c", "", true, []string{}},
+	}
+
+	for i, test := range tests {
+		ret, err := s.Lpop(test.key)
+		if (err != nil) != test.hasError {
+			t.Errorf("[run %d] Error missmatch: Expected: %t, had: %t", i, test.hasError, (err != nil))
+		}
+		if err != nil {
+			continue
+		}
+		if ret != test.ret {
+			t.Errorf("[run %d] mismatched return: %s != %s", i, test.ret, ret)
+		}
+		if !checkSlice(s.items[test.key].listValue, test.value) {
+			t.Errorf("[run %d] Values do not match: %v != %v", i, test.value, s.items[test.key].listValue)
+		}
+	}
+}
+
+func TestLlen(t *testing.T) {
+	s := New()
+	s.Set("a", []string{"a", "b", "c"})
+	s.Set("b", "string")
+
+	var tests = []struct {
+		key      string
+		length   int
+		hasError bool
+	}{
+		{"a", 3, false},
+		{"missing", 0, false},
+		{"b", 0, true},
+	}
+
+	for i, test := range tests {
+		n, err := s.Llen(test.key)
+		if (err != nil) != test.hasError {
+			t.Errorf("[run %d] Error missmatch: Expected: %t, had: %t", i, test.hasError, (err != nil))
+		}
+		if n != test.length {
+			t.Errorf("[run %d] length mismatch: %d != %d", i, test.length, n)
+		}
+	}
+}
+
+func TestLrange(t *testing.T) {
+	s := New()
+	s.Set("a", []string{"a", "b", "c", "d", "e"})
+	s.Set("b", "string")
+
+	var tests = []struct {
+		key      string
+		start    int
+		stop     int
+		result   []string
+		hasError bool
+	}{
+		{"a", 0, 2, []string{"a", "b", "c"}, false},
+		{"a", -2, -1, []string{"d", "e"}, false},
+		{"a", 1, 100, []string{"b", "c", "d", "e"}, false},
+		{"a", 3, 1, []string{}, false},
+		{"missing", 0, -1, []string{}, false},
+		{"b", 0, -1, nil, true},
+	}
+
+	for i, test := range tests {
+		res, err := s.Lrange(test.key, test.start, test.stop)
+		if (err != nil) != test.hasError {
+			t.Errorf("[run %d] Error missmatch: Expected: %t, had: %t", i, test.hasError, (err != nil))
+		}
+		if err != nil {
+			continue
+		}
+		if !checkSlice(res, test.result) {
+			t.Errorf("[run %d] Values do not match: %v != %v", i, test.result, res)
+		}
+	}
+}
+
 func TestMapGet(t *testing.T) {
 	s := New()
 	s.Set("a", map[string]string{"a": "b", "b": "c"})
@@ -341,6 +469,7 @@ func TestGetIndex(t *testing.T) {
 	}{
 		{"abc", []string{"abc"}},
 		{"cba", []string{}},
+		{"zzz", []string{}},
 		{"*d*", []string{}},
 		{"a*", []string{"aaa", "aaa:bbb:ccc", "abc"}},
 		{"*", []string{"aaa", "aaa:bbb:ccc", "abc", "ccc:aaa:bbb"}},
@@ -355,6 +484,15 @@ func TestGetIndex(t *testing.T) {
 	}
 }
 
+func TestGetIndexEmptyStore(t *testing.T) {
+	s := New()
+
+	res := s.GetIndex("anything")
+	if !checkSlice(res, []string{}) {
+		t.Errorf("expected no match against an empty index, got %v", res)
+	}
+}
+
 func checkSlice(a []string, b []string) bool {
 	if a == nil && b == nil {
 		return true
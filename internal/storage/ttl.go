@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+
+	"github.com/trenton42/miniredis/internal/pubsub"
+)
+
+// reaperInterval bounds how long an expired key can sit unreaped between
+// accesses: the background reaper wakes at least this often even if the
+// expiry heap's next deadline is further out, and at most this often so it
+// doesn't busy-loop when the heap is empty.
+const reaperInterval = 100 * time.Millisecond
+
+// reaperSampleSize caps how many keys the reaper inspects per wake, Redis
+// active-expiration style: a bounded sample keeps the sweep cheap instead
+// of scanning every tracked key on every tick.
+const reaperSampleSize = 20
+
+// expiryEntry is one key's expiration deadline, tracked in an expiryHeap so
+// the reaper can wake exactly when the next key is due instead of polling
+// the whole keyspace.
+type expiryEntry struct {
+	key       string
+	expiresAt time.Time
+	index     int // maintained by heap.Interface
+}
+
+// expiryHeap is a min-heap of expiryEntry ordered by expiresAt.
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*expiryEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
+
+// setExpiryLocked schedules key to expire at expiresAt, replacing any TTL it
+// already had. Callers must hold s.Lock.
+func (s *Storage) setExpiryLocked(key string, expiresAt time.Time) {
+	if entry, ok := s.expiryIndex[key]; ok {
+		entry.expiresAt = expiresAt
+		heap.Fix(&s.expiry, entry.index)
+		return
+	}
+	entry := &expiryEntry{key: key, expiresAt: expiresAt}
+	heap.Push(&s.expiry, entry)
+	s.expiryIndex[key] = entry
+}
+
+// removeExpiryLocked clears any TTL tracked for key. It is a no-op if key
+// has no TTL. Callers must hold s.Lock.
+func (s *Storage) removeExpiryLocked(key string) {
+	entry, ok := s.expiryIndex[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&s.expiry, entry.index)
+	delete(s.expiryIndex, key)
+}
+
+// expiredLocked reports whether key is tracked with a TTL that has already
+// passed. If so, it deletes the key (and its TTL bookkeeping) as a side
+// effect, the same lazy-expiration behaviour Redis applies on access.
+// Callers must hold s.Lock (not just RLock), since this can mutate state.
+func (s *Storage) expiredLocked(key string) bool {
+	entry, ok := s.expiryIndex[key]
+	if !ok {
+		return false
+	}
+	if entry.expiresAt.After(time.Now()) {
+		return false
+	}
+	s.expireKeyLocked(key)
+	return true
+}
+
+// expireKeyLocked removes key the same way Delete does, plus its TTL
+// bookkeeping. Callers must hold s.Lock.
+func (s *Storage) expireKeyLocked(key string) {
+	if _, ok := s.items[key]; ok {
+		s.updateIndex(key, false)
+	}
+	delete(s.items, key)
+	s.removeExpiryLocked(key)
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opDelete, Key: key})
+	}
+	s.publish(pubsub.OpExpired, key, "")
+}
+
+// Expire schedules key to expire after ttl, replacing any TTL it already
+// had. It returns an error if key does not exist.
+func (s *Storage) Expire(key string, ttl time.Duration) error {
+	s.Lock()
+	s.expiredLocked(key)
+	if _, ok := s.items[key]; !ok {
+		s.Unlock()
+		return fmt.Errorf("key %s does not exist", key)
+	}
+	expiresAt := time.Now().Add(ttl)
+	s.setExpiryLocked(key, expiresAt)
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opExpire, Key: key, ExpiresAt: expiresAt.UnixNano()})
+	}
+	s.Unlock()
+	s.ensureReaper()
+	return nil
+}
+
+// Persist removes any TTL on key, making it persistent again. It returns an
+// error if key does not exist.
+func (s *Storage) Persist(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.expiredLocked(key)
+	if _, ok := s.items[key]; !ok {
+		return fmt.Errorf("key %s does not exist", key)
+	}
+	s.removeExpiryLocked(key)
+	if s.walFile != nil {
+		s.writeWAL(walRecord{Op: opPersist, Key: key})
+	}
+	return nil
+}
+
+// TTL returns the time remaining before key expires. A persistent key (one
+// with no TTL) returns a negative duration, matching Redis's -1 sentinel in
+// spirit. It returns an error if key does not exist.
+func (s *Storage) TTL(key string) (time.Duration, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.expiredLocked(key)
+	if _, ok := s.items[key]; !ok {
+		return 0, fmt.Errorf("key %s does not exist", key)
+	}
+	entry, ok := s.expiryIndex[key]
+	if !ok {
+		return -1, nil
+	}
+	return time.Until(entry.expiresAt), nil
+}
+
+// ensureReaper starts the background active-expiration goroutine the first
+// time any key gets a TTL. Storage that never uses TTLs never pays for it.
+// Callers must NOT already hold s.Lock: it takes the lock itself so that
+// the fields it sets are visible to stopReaper under the same lock it
+// reads them with.
+func (s *Storage) ensureReaper() {
+	s.Lock()
+	defer s.Unlock()
+	s.reaperOnce.Do(func() {
+		s.reaperStop = make(chan struct{})
+		s.reaperWG.Add(1)
+		go s.reapLoop()
+	})
+}
+
+// reapLoop wakes at most every reaperInterval, or sooner if the expiry
+// heap's next deadline is closer, and samples a bounded subset of tracked
+// keys for expiration.
+func (s *Storage) reapLoop() {
+	defer s.reaperWG.Done()
+	timer := time.NewTimer(reaperInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.reaperStop:
+			return
+		case <-timer.C:
+			s.reapSample()
+			timer.Reset(s.nextReapDelay())
+		}
+	}
+}
+
+// nextReapDelay returns how long the reaper should sleep before its next
+// sweep: the time until the soonest tracked expiration, capped to
+// reaperInterval so the reaper still wakes periodically when the heap is
+// empty or its root is far in the future.
+func (s *Storage) nextReapDelay() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.expiry) == 0 {
+		return reaperInterval
+	}
+	d := time.Until(s.expiry[0].expiresAt)
+	if d < 0 {
+		return 0
+	}
+	if d > reaperInterval {
+		return reaperInterval
+	}
+	return d
+}
+
+// reapSample checks a bounded, effectively random subset of tracked keys
+// (Go's map iteration order is randomized per run) and deletes any that
+// have expired.
+func (s *Storage) reapSample() {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	checked := 0
+	for key, entry := range s.expiryIndex {
+		if checked >= reaperSampleSize {
+			break
+		}
+		checked++
+		if !entry.expiresAt.After(now) {
+			s.expireKeyLocked(key)
+		}
+	}
+}
+
+// snapshotExpiresLocked returns the current TTL deadlines in the shape
+// snapshotState stores them in. Callers must hold s.Lock or s.RLock.
+func (s *Storage) snapshotExpiresLocked() map[string]int64 {
+	if len(s.expiryIndex) == 0 {
+		return nil
+	}
+	expires := make(map[string]int64, len(s.expiryIndex))
+	for key, entry := range s.expiryIndex {
+		expires[key] = entry.expiresAt.UnixNano()
+	}
+	return expires
+}
+
+// restoreExpiriesLocked replaces the expiry heap/index with the deadlines
+// decoded from a snapshot, and reports whether any were restored so the
+// caller knows to start the reaper once it has released s.Lock (ensureReaper
+// takes the lock itself, so it cannot be called from in here). Callers must
+// hold s.Lock.
+func (s *Storage) restoreExpiriesLocked(expires map[string]int64) (hadEntries bool) {
+	s.expiry = nil
+	s.expiryIndex = make(map[string]*expiryEntry, len(expires))
+	for key, unixNano := range expires {
+		entry := &expiryEntry{key: key, expiresAt: time.Unix(0, unixNano)}
+		heap.Push(&s.expiry, entry)
+		s.expiryIndex[key] = entry
+	}
+	return len(expires) > 0
+}
+
+// stopReaper signals the reaper goroutine (if one was ever started) to exit
+// and waits for it to do so.
+func (s *Storage) stopReaper() {
+	s.Lock()
+	stop := s.reaperStop
+	s.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	s.reaperWG.Wait()
+}
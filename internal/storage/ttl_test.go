@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSetWithTTLExpiresLazily(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if err := s.SetWithTTL("a", "somevalue", time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.Get("a"); err == nil {
+		t.Error("expected Get on expired key to error")
+	}
+	if _, ok := s.items["a"]; ok {
+		t.Error("expected expired key to be lazily deleted from items")
+	}
+}
+
+func TestSetClearsExistingTTL(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if err := s.SetWithTTL("a", "somevalue", time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	if err := s.Set("a", "othervalue"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	val, err := s.Get("a")
+	if err != nil {
+		t.Fatalf("expected re-Set key to survive past the old TTL, got error: %v", err)
+	}
+	var got string
+	if err := json.Unmarshal(val, &got); err != nil || got != "othervalue" {
+		t.Errorf("value = %q, %v; want %q, nil", got, err, "othervalue")
+	}
+	if _, ok := s.expiryIndex["a"]; ok {
+		t.Error("expected plain Set to clear the stale expiry heap entry")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if err := s.Expire("missing", time.Second); err == nil {
+		t.Error("expected error expiring a missing key")
+	}
+
+	s.Set("a", "somevalue")
+	if err := s.Expire("a", time.Millisecond); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.Get("a"); err == nil {
+		t.Error("expected Get on expired key to error")
+	}
+}
+
+func TestPersist(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if err := s.Persist("missing"); err == nil {
+		t.Error("expected error persisting a missing key")
+	}
+
+	s.Set("a", "somevalue")
+	if err := s.Expire("a", time.Millisecond); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if err := s.Persist("a"); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.Get("a"); err != nil {
+		t.Errorf("expected persisted key to survive, got error: %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if _, err := s.TTL("missing"); err == nil {
+		t.Error("expected error for TTL of a missing key")
+	}
+
+	s.Set("a", "somevalue")
+	ttl, err := s.TTL("a")
+	if err != nil {
+		t.Fatalf("TTL: %v", err)
+	}
+	if ttl >= 0 {
+		t.Errorf("expected negative TTL for a persistent key, got %v", ttl)
+	}
+
+	s.Expire("a", time.Minute)
+	ttl, err = s.TTL("a")
+	if err != nil {
+		t.Fatalf("TTL: %v", err)
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, want something in (0, 1m]", ttl)
+	}
+}
+
+func TestGetIndexExcludesExpiredKeys(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.Set("aaa", "somevalue")
+	s.SetWithTTL("aab", "somevalue", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if got := s.GetIndex("aa*"); len(got) != 1 || got[0] != "aaa" {
+		t.Errorf("GetIndex(\"aa*\") = %v, want [aaa]", got)
+	}
+}
+
+func TestReaperActivelyExpiresKeys(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if err := s.SetWithTTL("a", "somevalue", time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.Lock()
+		_, ok := s.items["a"]
+		s.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected background reaper to actively delete the expired key")
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// WAL op codes, one per mutating Storage method.
+const (
+	opSet byte = iota + 1
+	opDelete
+	opAppend
+	opPop
+	opMapSet
+	opMapDelete
+	opLpush
+	opLpop
+	opExpire
+	opPersist
+)
+
+// walRecord is the unit appended to wal.log for every mutation. Only the
+// fields relevant to Op are populated; the rest are left zero. ExpiresAt is
+// a Unix nanosecond timestamp (0 means no TTL) recorded as an absolute
+// deadline rather than a relative duration, so replay doesn't grant a key
+// extra lifetime for however long it spent on disk.
+type walRecord struct {
+	Op        byte
+	Key       string
+	MKey      string
+	Value     string
+	Type      string
+	List      []string
+	Map       map[string]string
+	ExpiresAt int64
+}
+
+// encode gob-encodes the record and wraps it in a length-prefixed frame with
+// a trailing CRC32 of the payload, so a torn write left behind by a crash
+// can be detected on replay instead of silently corrupting the log.
+func (r walRecord) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return nil, err
+	}
+	payload := buf.Bytes()
+	frame := make([]byte, 4+len(payload)+4)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	copy(frame[4:], payload)
+	binary.BigEndian.PutUint32(frame[4+len(payload):], crc32.ChecksumIEEE(payload))
+	return frame, nil
+}
+
+// readWALRecord reads one framed record from r. It returns io.EOF when the
+// log ends cleanly on a frame boundary. Any other error (a short read or a
+// CRC mismatch) means the final record was torn by a crash; the caller
+// should stop replaying and discard the log from that point on.
+func readWALRecord(r io.Reader) (*walRecord, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(r, crcBuf[:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(crcBuf[:]) {
+		return nil, fmt.Errorf("wal: crc mismatch, record truncated or corrupt")
+	}
+	var rec walRecord
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("wal: %v", err)
+	}
+	return &rec, nil
+}
+
+// writeWAL appends rec to the WAL and, depending on opts.Sync, fsyncs it
+// before returning. Callers must hold s.Lock and must only call this when
+// s.walFile is non-nil.
+func (s *Storage) writeWAL(rec walRecord) {
+	frame, err := rec.encode()
+	if err != nil {
+		// None of the fixed record shapes above should ever fail to encode.
+		// The in-memory mutation already happened; the next snapshot will
+		// still capture it, so there is nothing useful to do but skip it.
+		return
+	}
+	if _, err := s.walFile.Write(frame); err != nil {
+		return
+	}
+	s.walBytes += int64(len(frame))
+	if s.opts.Sync == SyncAlways {
+		s.walFile.Sync()
+	}
+	if s.opts.MaxWALBytes > 0 && s.walBytes >= s.opts.MaxWALBytes {
+		select {
+		case s.snapshotCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// applyRecord replays a single WAL or snapshot-truncated-log record against
+// the in-memory state by calling straight back into the public API. Since
+// replay happens before s.walFile is attached, writeWAL is a no-op for it,
+// so no WAL record is re-written for a record being replayed.
+func (s *Storage) applyRecord(rec *walRecord) {
+	switch rec.Op {
+	case opSet:
+		var value interface{}
+		switch rec.Type {
+		case "list":
+			value = rec.List
+		case "map":
+			value = rec.Map
+		default:
+			value = rec.Value
+		}
+		if rec.ExpiresAt == 0 {
+			s.Set(rec.Key, value)
+			return
+		}
+		remaining := time.Until(time.Unix(0, rec.ExpiresAt))
+		if remaining <= 0 {
+			// Already expired by the time we're replaying it; don't bother
+			// recreating a key just to have the reaper clean it up.
+			return
+		}
+		s.SetWithTTL(rec.Key, value, remaining)
+	case opDelete:
+		s.Delete(rec.Key)
+	case opAppend:
+		s.Append(rec.Key, rec.Value)
+	case opPop:
+		s.Pop(rec.Key)
+	case opMapSet:
+		s.MapSet(rec.Key, rec.MKey, rec.Value)
+	case opMapDelete:
+		s.MapDelete(rec.Key, rec.MKey)
+	case opLpush:
+		s.Lpush(rec.Key, rec.Value)
+	case opLpop:
+		s.Lpop(rec.Key)
+	case opExpire:
+		remaining := time.Until(time.Unix(0, rec.ExpiresAt))
+		if remaining <= 0 {
+			s.Delete(rec.Key)
+			return
+		}
+		s.Expire(rec.Key, remaining)
+	case opPersist:
+		s.Persist(rec.Key)
+	}
+}